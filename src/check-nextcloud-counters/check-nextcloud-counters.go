@@ -1,13 +1,19 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/kr/pretty"
@@ -17,11 +23,32 @@ var hostname = flag.String("hostname", "", "hostname of nextcloud instance")
 var uri = flag.String("uri", "/ocs/v2.php/apps/serverinfo/api/v1/info", "URI containing the status info")
 var username = flag.String("username", "", "Nextcloud user name (admin permission reqd")
 var password = flag.String("password", "", "Password to authenticate against nextcloud")
-var counter = flag.String("counter", "", "Counter to be monitored [AppUdatesAvailable|FreeSpace|NumShares|ActiveUsers5Min")
+var authToken = flag.String("auth-token", "", "App password / bearer token to authenticate against nextcloud instead of --password (generate one under Personal -> Security)")
+var counter = flag.String("counter", "", "Counter to be monitored [AppUdatesAvailable|FreeSpace|NumShares|ActiveUsers5Min|SystemUpdateAvailable|CpuLoad1|CpuLoad5|CpuLoad15|MemUsedPercent|SwapUsedPercent|DbSize|NumUsers|NumFiles|NumStorages|ActiveUsers1h|ActiveUsers24h|NumSharesLinkNoPassword|NumFedSharesSent|NumFedSharesReceived")
 var critical = flag.Int64("critical", 0, "Critical Value")
 var warning = flag.Int64("warning", 0, "Warning Value")
 var debug = flag.Bool("debug", false, "show debugging output")
 var perfdata = flag.Bool("perfdata", false, "output perfdata")
+var enableInfoUpdate = flag.Bool("enable-info-update", false, "also request core/server update availability info (adds skipUpdate=false to the serverinfo query)")
+var cacheTTL = flag.Duration("cache-ttl", 60*time.Second, "how long to reuse a cached serverinfo response for (0 disables caching)")
+var outputFormat = flag.String("output", "nagios", "output format to produce: nagios (default, a single --counter check) or prometheus (writes --textfile covering every counter)")
+var textfile = flag.String("textfile", "", "path to write Prometheus textfile-collector output to (required with --output=prometheus)")
+var scheme = flag.String("scheme", "https", "scheme to use when contacting the Nextcloud instance (https or http)")
+var insecureSkipVerify = flag.Bool("insecure-skip-verify", false, "skip TLS certificate verification (for testing against self-signed certs only)")
+var caFile = flag.String("ca-file", "", "path to a PEM CA bundle to trust in addition to the system roots, for private CAs")
+var clientCert = flag.String("client-cert", "", "path to a PEM client certificate, for mutual TLS")
+var clientKey = flag.String("client-key", "", "path to the PEM private key matching --client-cert")
+var proxy = flag.String("proxy", "", "HTTP(S) proxy URL to use, e.g. http://proxy.example.com:3128")
+var timeout = flag.Duration("timeout", 10*time.Second, "timeout for the HTTP request against the serverinfo endpoint")
+
+// set by fetchPerformanceInfo when counter is SystemUpdateAvailable, so main
+// can report the version that is available without changing the int64 return
+// convention used for every other counter.
+var updateAvailableVersion string
+
+// set by fetchServerInfo, reported in perfdata so operators can tell whether
+// a result came from the cache and how stale it is.
+var cacheAge time.Duration
 
 func debugprint(msg string) {
 	if *debug == true {
@@ -29,91 +56,174 @@ func debugprint(msg string) {
 	}
 }
 
-func fetchPerformanceInfo(counter string) int64 {
-	//generated by https://mholt.github.io/json-to-go/
-	type NcPerfData struct {
-		Ocs struct {
-			Meta struct {
-				Status     string `json:"status"`
-				Statuscode int    `json:"statuscode"`
-				Message    string `json:"message"`
-			} `json:"meta"`
-			Data struct {
-				Nextcloud struct {
-					System struct {
-						Version             string    `json:"version"`
-						Theme               string    `json:"theme"`
-						EnableAvatars       string    `json:"enable_avatars"`
-						EnablePreviews      string    `json:"enable_previews"`
-						MemcacheLocal       string    `json:"memcache.local"`
-						MemcacheDistributed string    `json:"memcache.distributed"`
-						FilelockingEnabled  string    `json:"filelocking.enabled"`
-						MemcacheLocking     string    `json:"memcache.locking"`
-						Debug               string    `json:"debug"`
-						Freespace           int64     `json:"freespace"`
-						Cpuload             []float64 `json:"cpuload"`
-						MemTotal            int       `json:"mem_total"`
-						MemFree             int       `json:"mem_free"`
-						SwapTotal           int       `json:"swap_total"`
-						SwapFree            int       `json:"swap_free"`
-						Apps                struct {
-							NumInstalled        int           `json:"num_installed"`
-							NumUpdatesAvailable int           `json:"num_updates_available"`
-							AppUpdates          []interface{} `json:"app_updates"`
-						} `json:"apps"`
-					} `json:"system"`
-					Storage struct {
-						NumUsers         int `json:"num_users"`
-						NumFiles         int `json:"num_files"`
-						NumStorages      int `json:"num_storages"`
-						NumStoragesLocal int `json:"num_storages_local"`
-						NumStoragesHome  int `json:"num_storages_home"`
-						NumStoragesOther int `json:"num_storages_other"`
-					} `json:"storage"`
-					Shares struct {
-						NumShares               int `json:"num_shares"`
-						NumSharesUser           int `json:"num_shares_user"`
-						NumSharesGroups         int `json:"num_shares_groups"`
-						NumSharesLink           int `json:"num_shares_link"`
-						NumSharesMail           int `json:"num_shares_mail"`
-						NumSharesRoom           int `json:"num_shares_room"`
-						NumSharesLinkNoPassword int `json:"num_shares_link_no_password"`
-						NumFedSharesSent        int `json:"num_fed_shares_sent"`
-						NumFedSharesReceived    int `json:"num_fed_shares_received"`
-						Permissions31           int `json:"permissions_3_1"`
-					} `json:"shares"`
-				} `json:"nextcloud"`
-				Server struct {
-					Webserver string `json:"webserver"`
-					Php       struct {
-						Version           string `json:"version"`
-						MemoryLimit       int    `json:"memory_limit"`
-						MaxExecutionTime  int    `json:"max_execution_time"`
-						UploadMaxFilesize int    `json:"upload_max_filesize"`
-					} `json:"php"`
-					Database struct {
-						Type    string `json:"type"`
-						Version string `json:"version"`
-						Size    int    `json:"size"`
-					} `json:"database"`
-				} `json:"server"`
-				ActiveUsers struct {
-					Last5Minutes int `json:"last5minutes"`
-					Last1Hour    int `json:"last1hour"`
-					Last24Hours  int `json:"last24hours"`
-				} `json:"activeUsers"`
-			} `json:"data"`
-		} `json:"ocs"`
-	}
-
-	perfURL := fmt.Sprintf("https://%s/%s?format=json", *hostname, *uri)
+// generated by https://mholt.github.io/json-to-go/
+type NcPerfData struct {
+	Ocs struct {
+		Meta struct {
+			Status     string `json:"status"`
+			Statuscode int    `json:"statuscode"`
+			Message    string `json:"message"`
+		} `json:"meta"`
+		Data struct {
+			Nextcloud struct {
+				System struct {
+					Version             string    `json:"version"`
+					Theme               string    `json:"theme"`
+					EnableAvatars       string    `json:"enable_avatars"`
+					EnablePreviews      string    `json:"enable_previews"`
+					MemcacheLocal       string    `json:"memcache.local"`
+					MemcacheDistributed string    `json:"memcache.distributed"`
+					FilelockingEnabled  string    `json:"filelocking.enabled"`
+					MemcacheLocking     string    `json:"memcache.locking"`
+					Debug               string    `json:"debug"`
+					Freespace           int64     `json:"freespace"`
+					Cpuload             []float64 `json:"cpuload"`
+					MemTotal            int       `json:"mem_total"`
+					MemFree             int       `json:"mem_free"`
+					SwapTotal           int       `json:"swap_total"`
+					SwapFree            int       `json:"swap_free"`
+					Apps                struct {
+						NumInstalled        int           `json:"num_installed"`
+						NumUpdatesAvailable int           `json:"num_updates_available"`
+						AppUpdates          []interface{} `json:"app_updates"`
+					} `json:"apps"`
+					Update struct {
+						Available        bool   `json:"available"`
+						AvailableVersion string `json:"available_version"`
+					} `json:"update"`
+				} `json:"system"`
+				Storage struct {
+					NumUsers         int `json:"num_users"`
+					NumFiles         int `json:"num_files"`
+					NumStorages      int `json:"num_storages"`
+					NumStoragesLocal int `json:"num_storages_local"`
+					NumStoragesHome  int `json:"num_storages_home"`
+					NumStoragesOther int `json:"num_storages_other"`
+				} `json:"storage"`
+				Shares struct {
+					NumShares               int `json:"num_shares"`
+					NumSharesUser           int `json:"num_shares_user"`
+					NumSharesGroups         int `json:"num_shares_groups"`
+					NumSharesLink           int `json:"num_shares_link"`
+					NumSharesMail           int `json:"num_shares_mail"`
+					NumSharesRoom           int `json:"num_shares_room"`
+					NumSharesLinkNoPassword int `json:"num_shares_link_no_password"`
+					NumFedSharesSent        int `json:"num_fed_shares_sent"`
+					NumFedSharesReceived    int `json:"num_fed_shares_received"`
+					Permissions31           int `json:"permissions_3_1"`
+				} `json:"shares"`
+			} `json:"nextcloud"`
+			Server struct {
+				Webserver string `json:"webserver"`
+				Php       struct {
+					Version           string `json:"version"`
+					MemoryLimit       int    `json:"memory_limit"`
+					MaxExecutionTime  int    `json:"max_execution_time"`
+					UploadMaxFilesize int    `json:"upload_max_filesize"`
+				} `json:"php"`
+				Database struct {
+					Type    string `json:"type"`
+					Version string `json:"version"`
+					Size    int    `json:"size"`
+				} `json:"database"`
+			} `json:"server"`
+			ActiveUsers struct {
+				Last5Minutes int `json:"last5minutes"`
+				Last1Hour    int `json:"last1hour"`
+				Last24Hours  int `json:"last24hours"`
+			} `json:"activeUsers"`
+		} `json:"data"`
+	} `json:"ocs"`
+}
+
+// cacheFilePath returns a path under /var/tmp unique to the exact request
+// that would be made (host, credentials, and every flag that changes the
+// serverinfo URL), so two invocations that ask for different data never
+// share a cache entry. In particular, a plain poll must not satisfy a later
+// --enable-info-update poll with a cached response that never requested
+// update info.
+func cacheFilePath() string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s:%s:%s:%s:%s:%t", *scheme, *hostname, *uri, *username, *authToken, *enableInfoUpdate)))
+	return fmt.Sprintf("/var/tmp/check_nextcloud_%x.json", hash)
+}
+
+// buildHTTPClient constructs an http.Client honoring the TLS and proxy flags,
+// mirroring the transport setup nextcloud-spreed-signaling's BackendClient
+// uses, so the check works behind reverse proxies and with private CAs.
+func buildHTTPClient() *http.Client {
+	tlsConfig := &tls.Config{InsecureSkipVerify: *insecureSkipVerify}
+
+	if *caFile != "" {
+		caCert, err := ioutil.ReadFile(*caFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(caCert) {
+			log.Fatal(fmt.Sprintf("failed to parse CA certificate from %s", *caFile))
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if *clientCert != "" {
+		cert, err := tls.LoadX509KeyPair(*clientCert, *clientKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment}
+
+	if *proxy != "" {
+		proxyURL, err := url.Parse(*proxy)
+		if err != nil {
+			log.Fatal(err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	return &http.Client{Transport: transport, Timeout: *timeout}
+}
+
+// fetchServerInfo returns the raw serverinfo JSON, either from a fresh
+// on-disk cache (see --cache-ttl) or by fetching it over HTTP, in which case
+// the cache is refreshed. cacheAge is set as a side effect for perfdata.
+func fetchServerInfo() []byte {
+	cachePath := cacheFilePath()
+	if *cacheTTL > 0 {
+		if info, err := os.Stat(cachePath); err == nil {
+			age := time.Since(info.ModTime())
+			if age < *cacheTTL {
+				if b, err := ioutil.ReadFile(cachePath); err == nil {
+					debugprint(fmt.Sprintf("using cached serverinfo response from %s (age %s)", cachePath, age))
+					cacheAge = age
+					return b
+				}
+			}
+		}
+	}
+
+	perfURL := fmt.Sprintf("%s://%s/%s?format=json", *scheme, *hostname, *uri)
+	if *enableInfoUpdate {
+		perfURL = fmt.Sprintf("%s&skipUpdate=false", perfURL)
+	}
 	debugprint(fmt.Sprintf("initiating GET request to %s", perfURL))
-	req, err := http.NewRequest("GET", perfURL, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", perfURL, nil)
 	if err != nil {
 		log.Fatal(err)
 	}
-	req.SetBasicAuth(*username, *password)
-	client := &http.Client{}
+	if *authToken != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", *authToken))
+	} else {
+		req.SetBasicAuth(*username, *password)
+	}
+	client := buildHTTPClient()
 	resp, err := client.Do(req)
 	if err != nil {
 		log.Fatal(err)
@@ -131,9 +241,39 @@ func fetchPerformanceInfo(counter string) int64 {
 		log.Fatal(err)
 	}
 	debugprint(string(b))
+
+	if *cacheTTL > 0 {
+		writeFileAtomically(cachePath, b)
+	}
+	cacheAge = 0
+	return b
+}
+
+// writeFileAtomically writes b to a temp file next to path and renames it
+// into place, so a concurrent reader never observes a partially written
+// file.
+func writeFileAtomically(path string, b []byte) {
+	tmp := fmt.Sprintf("%s.tmp-%d", path, os.Getpid())
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		debugprint(fmt.Sprintf("failed writing cache file %s: %s", tmp, err))
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		debugprint(fmt.Sprintf("failed renaming cache file %s to %s: %s", tmp, path, err))
+	}
+}
+
+func fetchPerformanceInfo(counter string) int64 {
+	b := fetchServerInfo()
 	var m NcPerfData
 	json.Unmarshal(b, &m)
+	return extractCounter(m, counter)
+}
 
+// extractCounter reads a single counter out of an already-parsed serverinfo
+// response, so callers that need several counters (writePrometheusTextfile)
+// can parse the response once and read every counter from it.
+func extractCounter(m NcPerfData, counter string) int64 {
 	switch counter {
 	case "AppUdatesAvailable":
 		return int64(m.Ocs.Data.Nextcloud.System.Apps.NumUpdatesAvailable)
@@ -143,17 +283,108 @@ func fetchPerformanceInfo(counter string) int64 {
 		return int64(m.Ocs.Data.Nextcloud.Shares.NumShares)
 	case "ActiveUsers5Min":
 		return int64(m.Ocs.Data.ActiveUsers.Last5Minutes)
+	case "SystemUpdateAvailable":
+		updateAvailableVersion = m.Ocs.Data.Nextcloud.System.Update.AvailableVersion
+		if m.Ocs.Data.Nextcloud.System.Update.Available {
+			return 1
+		}
+		return 0
+	case "CpuLoad1":
+		return cpuLoadAt(m.Ocs.Data.Nextcloud.System.Cpuload, 0)
+	case "CpuLoad5":
+		return cpuLoadAt(m.Ocs.Data.Nextcloud.System.Cpuload, 1)
+	case "CpuLoad15":
+		return cpuLoadAt(m.Ocs.Data.Nextcloud.System.Cpuload, 2)
+	case "MemUsedPercent":
+		return usedPercent(m.Ocs.Data.Nextcloud.System.MemTotal, m.Ocs.Data.Nextcloud.System.MemFree)
+	case "SwapUsedPercent":
+		return usedPercent(m.Ocs.Data.Nextcloud.System.SwapTotal, m.Ocs.Data.Nextcloud.System.SwapFree)
+	case "DbSize":
+		return int64(m.Ocs.Data.Server.Database.Size)
+	case "NumUsers":
+		return int64(m.Ocs.Data.Nextcloud.Storage.NumUsers)
+	case "NumFiles":
+		return int64(m.Ocs.Data.Nextcloud.Storage.NumFiles)
+	case "NumStorages":
+		return int64(m.Ocs.Data.Nextcloud.Storage.NumStorages)
+	case "ActiveUsers1h":
+		return int64(m.Ocs.Data.ActiveUsers.Last1Hour)
+	case "ActiveUsers24h":
+		return int64(m.Ocs.Data.ActiveUsers.Last24Hours)
+	case "NumSharesLinkNoPassword":
+		return int64(m.Ocs.Data.Nextcloud.Shares.NumSharesLinkNoPassword)
+	case "NumFedSharesSent":
+		return int64(m.Ocs.Data.Nextcloud.Shares.NumFedSharesSent)
+	case "NumFedSharesReceived":
+		return int64(m.Ocs.Data.Nextcloud.Shares.NumFedSharesReceived)
 	default:
 		return -1
 	}
 
 }
 
+// counterDirections holds, for counters where a *lower* value is the
+// unhealthy direction (e.g. free space running out), the string "lower".
+// Any counter not listed here defaults to "higher", i.e. warning/critical
+// fire once the value reaches or exceeds the threshold.
+var counterDirections = map[string]string{
+	"FreeSpace": "lower",
+}
+
+func counterDirection(counter string) string {
+	if direction, ok := counterDirections[counter]; ok {
+		return direction
+	}
+	return "higher"
+}
+
+// cpuLoadAtScale is the factor CpuLoad1/5/15 are multiplied by before being
+// truncated to an int64, so a load average like 0.7 isn't rounded down to a
+// useless 0. --warning/--critical for these counters are in hundredths of
+// the load average (e.g. 150 means a load of 1.5).
+const cpuLoadAtScale = 100
+
+func cpuLoadAt(cpuload []float64, index int) int64 {
+	if index >= len(cpuload) {
+		return -1
+	}
+	return int64(cpuload[index] * cpuLoadAtScale)
+}
+
+func usedPercent(total int, free int) int64 {
+	if total == 0 {
+		return -1
+	}
+	return int64((total - free) * 100 / total)
+}
+
+// checkAuthArguments validates the auth flags shared by every output mode.
+func checkAuthArguments() {
+	if *password != "" && *authToken != "" {
+		nagiosResult(3, "Only one of --password or --auth-token may be set")
+	}
+	if *password == "" && *authToken == "" {
+		nagiosResult(3, "One of --password or --auth-token is required")
+	}
+}
+
 func checkArguments(counter string, warning int64, critical int64) {
-	if warning >= critical {
-		nagiosResult(3, "Warning must be smaller than Critical")
+	checkAuthArguments()
+	if counterDirection(counter) == "lower" {
+		if warning <= critical {
+			nagiosResult(3, "Warning must be greater than Critical")
+		}
+	} else {
+		if warning >= critical {
+			nagiosResult(3, "Warning must be smaller than Critical")
+		}
+	}
+	allowedCounters := []string{
+		"AppUdatesAvailable", "FreeSpace", "NumShares", "ActiveUsers5Min", "SystemUpdateAvailable",
+		"CpuLoad1", "CpuLoad5", "CpuLoad15", "MemUsedPercent", "SwapUsedPercent", "DbSize",
+		"NumUsers", "NumFiles", "NumStorages", "ActiveUsers1h", "ActiveUsers24h",
+		"NumSharesLinkNoPassword", "NumFedSharesSent", "NumFedSharesReceived",
 	}
-	allowedCounters := []string{"AppUdatesAvailable", "FreeSpace", "NumShares", "ActiveUsers5Min"}
 	for c := range allowedCounters {
 		if allowedCounters[c] == counter {
 			return
@@ -180,30 +411,132 @@ func nagiosResult(ret int, message string) {
 	}
 }
 
+// prometheusMetric maps one of our counters onto a metric name/label pair
+// mirroring the naming used by xperimental/nextcloud-exporter. Entries that
+// share a metric name (e.g. the CpuLoad* or ActiveUsers* family) must stay
+// adjacent so writePrometheusTextfile only emits one HELP/TYPE block each.
+type prometheusMetric struct {
+	counter string
+	metric  string
+	label   string
+	help    string
+	// scale divides the counter's int64 value back down before emitting it,
+	// undoing a scale factor like cpuLoadAtScale applied so the counter can
+	// carry sub-1 precision as an int64. Zero means "emit the value as-is".
+	scale float64
+}
+
+var prometheusMetrics = []prometheusMetric{
+	{counter: "FreeSpace", metric: "nextcloud_system_freespace_bytes", help: "Free disk space on the Nextcloud data storage, in bytes."},
+	{counter: "AppUdatesAvailable", metric: "nextcloud_app_updates_available", help: "Number of installed apps with an update available."},
+	{counter: "SystemUpdateAvailable", metric: "nextcloud_system_update_available", help: "Whether a Nextcloud core/server update is available."},
+	{counter: "CpuLoad1", metric: "nextcloud_system_cpuload", label: `{period="1"}`, help: "System load average.", scale: cpuLoadAtScale},
+	{counter: "CpuLoad5", metric: "nextcloud_system_cpuload", label: `{period="5"}`, help: "System load average.", scale: cpuLoadAtScale},
+	{counter: "CpuLoad15", metric: "nextcloud_system_cpuload", label: `{period="15"}`, help: "System load average.", scale: cpuLoadAtScale},
+	{counter: "MemUsedPercent", metric: "nextcloud_system_mem_used_percent", help: "Percentage of system memory in use."},
+	{counter: "SwapUsedPercent", metric: "nextcloud_system_swap_used_percent", help: "Percentage of swap space in use."},
+	{counter: "DbSize", metric: "nextcloud_database_size_bytes", help: "Size of the Nextcloud database, in bytes."},
+	{counter: "NumUsers", metric: "nextcloud_users_total", help: "Total number of users."},
+	{counter: "NumFiles", metric: "nextcloud_files_total", help: "Total number of files managed by Nextcloud."},
+	{counter: "NumStorages", metric: "nextcloud_storages_total", help: "Total number of configured storages."},
+	{counter: "NumShares", metric: "nextcloud_shares_total", label: `{type="all"}`, help: "Number of shares, by type."},
+	{counter: "NumSharesLinkNoPassword", metric: "nextcloud_shares_total", label: `{type="link_no_password"}`, help: "Number of shares, by type."},
+	{counter: "NumFedSharesSent", metric: "nextcloud_shares_total", label: `{type="federated_sent"}`, help: "Number of shares, by type."},
+	{counter: "NumFedSharesReceived", metric: "nextcloud_shares_total", label: `{type="federated_received"}`, help: "Number of shares, by type."},
+	{counter: "ActiveUsers5Min", metric: "nextcloud_active_users", label: `{window="5m"}`, help: "Number of users active in the given time window."},
+	{counter: "ActiveUsers1h", metric: "nextcloud_active_users", label: `{window="1h"}`, help: "Number of users active in the given time window."},
+	{counter: "ActiveUsers24h", metric: "nextcloud_active_users", label: `{window="24h"}`, help: "Number of users active in the given time window."},
+}
+
+// writePrometheusTextfile fetches the serverinfo response exactly once, then
+// reads every known counter out of that single parsed response, and writes
+// the result as a node_exporter textfile-collector file.
+func writePrometheusTextfile() {
+	startTime := time.Now()
+	// Prometheus mode always reports nextcloud_system_update_available, so it
+	// always needs update info, regardless of whether --enable-info-update
+	// was passed; otherwise the metric would silently read as 0 forever.
+	*enableInfoUpdate = true
+	b := fetchServerInfo()
+	var m NcPerfData
+	json.Unmarshal(b, &m)
+
+	var lines []string
+	lastMetric := ""
+	for _, pm := range prometheusMetrics {
+		value := extractCounter(m, pm.counter)
+		if value == -1 {
+			debugprint(fmt.Sprintf("skipping %s: unknown value", pm.counter))
+			continue
+		}
+		if pm.metric != lastMetric {
+			lines = append(lines, fmt.Sprintf("# HELP %s %s", pm.metric, pm.help))
+			lines = append(lines, fmt.Sprintf("# TYPE %s gauge", pm.metric))
+			lastMetric = pm.metric
+		}
+		if pm.scale != 0 {
+			lines = append(lines, fmt.Sprintf("%s%s %g", pm.metric, pm.label, float64(value)/pm.scale))
+		} else {
+			lines = append(lines, fmt.Sprintf("%s%s %d", pm.metric, pm.label, value))
+		}
+	}
+	lines = append(lines, "# HELP nextcloud_scrape_duration_seconds Time taken to fetch and parse the serverinfo response.")
+	lines = append(lines, "# TYPE nextcloud_scrape_duration_seconds gauge")
+	lines = append(lines, fmt.Sprintf("nextcloud_scrape_duration_seconds %f", time.Since(startTime).Seconds()))
+
+	writeFileAtomically(*textfile, []byte(strings.Join(lines, "\n")+"\n"))
+}
+
 func main() {
 
 	flag.Parse()
 
+	if *outputFormat == "prometheus" {
+		if *textfile == "" {
+			nagiosResult(3, "--textfile is required when --output=prometheus")
+		}
+		checkAuthArguments()
+		writePrometheusTextfile()
+		os.Exit(0)
+	}
+
 	checkArguments(*counter, *warning, *critical)
 	startTime := time.Now()
 	perfInfo := fetchPerformanceInfo(*counter)
 	endTime := time.Now()
 	runtime := endTime.Sub(startTime)
 	result := fmt.Sprintf("%s: %s", *counter, fmt.Sprintf("%d", perfInfo))
+	if *counter == "SystemUpdateAvailable" && updateAvailableVersion != "" {
+		result = fmt.Sprintf("%s (version %s available)", result, updateAvailableVersion)
+	}
+	if *counter == "CpuLoad1" || *counter == "CpuLoad5" || *counter == "CpuLoad15" {
+		result = fmt.Sprintf("%s (load avg %.2f)", result, float64(perfInfo)/cpuLoadAtScale)
+	}
 	if *perfdata {
-		result = fmt.Sprintf("%s | %s=%d,runtime=%s", result, *counter, perfInfo, runtime)
+		result = fmt.Sprintf("%s | %s=%d,runtime=%s,cache_age=%s", result, *counter, perfInfo, runtime, cacheAge)
+		if *counter == "SystemUpdateAvailable" && updateAvailableVersion != "" {
+			result = fmt.Sprintf("%s,available_version=%s", result, updateAvailableVersion)
+		}
 	}
 	if perfInfo == -1 {
 		nagiosResult(3, fmt.Sprintf("Unknown value for %s", *counter))
 	}
-	if perfInfo < *warning {
-		nagiosResult(0, result)
-	}
-	if perfInfo >= *warning {
-		nagiosResult(1, result)
-	}
-	if perfInfo >= *critical {
-		nagiosResult(2, result)
+	if counterDirection(*counter) == "lower" {
+		if perfInfo <= *critical {
+			nagiosResult(2, result)
+		} else if perfInfo <= *warning {
+			nagiosResult(1, result)
+		} else {
+			nagiosResult(0, result)
+		}
+	} else {
+		if perfInfo >= *critical {
+			nagiosResult(2, result)
+		} else if perfInfo >= *warning {
+			nagiosResult(1, result)
+		} else {
+			nagiosResult(0, result)
+		}
 	}
 	//debugprint(fmt.Sprintf("Total runtime: %s", runtime))
 }
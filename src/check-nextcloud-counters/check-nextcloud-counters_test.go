@@ -0,0 +1,125 @@
+package main
+
+import "testing"
+
+func TestUsedPercent(t *testing.T) {
+	cases := []struct {
+		name        string
+		total, free int
+		want        int64
+	}{
+		{"half used", 100, 50, 50},
+		{"truncates down", 100, 33, 67},
+		{"nothing free", 100, 0, 100},
+		{"everything free", 100, 100, 0},
+		{"zero total guarded", 0, 0, -1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := usedPercent(c.total, c.free); got != c.want {
+				t.Errorf("usedPercent(%d, %d) = %d, want %d", c.total, c.free, got, c.want)
+			}
+		})
+	}
+}
+
+func TestCpuLoadAt(t *testing.T) {
+	cpuload := []float64{0.7, 1.9, 0.15}
+
+	cases := []struct {
+		name  string
+		index int
+		want  int64
+	}{
+		{"sub-1 load isn't truncated to 0", 0, 70},
+		{"above-1 load", 1, 190},
+		{"fractional load", 2, 15},
+		{"index out of range", 3, -1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := cpuLoadAt(cpuload, c.index); got != c.want {
+				t.Errorf("cpuLoadAt(%v, %d) = %d, want %d", cpuload, c.index, got, c.want)
+			}
+		})
+	}
+}
+
+func TestCounterDirection(t *testing.T) {
+	cases := []struct {
+		counter string
+		want    string
+	}{
+		{"FreeSpace", "lower"},
+		{"CpuLoad1", "higher"},
+		{"SomeUnknownCounter", "higher"},
+	}
+	for _, c := range cases {
+		t.Run(c.counter, func(t *testing.T) {
+			if got := counterDirection(c.counter); got != c.want {
+				t.Errorf("counterDirection(%q) = %q, want %q", c.counter, got, c.want)
+			}
+		})
+	}
+}
+
+func TestExtractCounter(t *testing.T) {
+	var m NcPerfData
+	m.Ocs.Data.Nextcloud.System.Freespace = 12345
+	m.Ocs.Data.Nextcloud.System.Update.Available = true
+	m.Ocs.Data.Nextcloud.System.Update.AvailableVersion = "25.0.1"
+	m.Ocs.Data.Nextcloud.Storage.NumUsers = 42
+
+	cases := []struct {
+		counter string
+		want    int64
+	}{
+		{"FreeSpace", 12345},
+		{"NumUsers", 42},
+		{"SystemUpdateAvailable", 1},
+		{"NotACounter", -1},
+	}
+	for _, c := range cases {
+		t.Run(c.counter, func(t *testing.T) {
+			if got := extractCounter(m, c.counter); got != c.want {
+				t.Errorf("extractCounter(m, %q) = %d, want %d", c.counter, got, c.want)
+			}
+		})
+	}
+
+	if updateAvailableVersion != "25.0.1" {
+		t.Errorf("extractCounter did not populate updateAvailableVersion, got %q", updateAvailableVersion)
+	}
+}
+
+// TestExtractCounterSystemUpdateUnavailable guards the "no update" branch
+// separately since it shares a counter name with the "available" case above
+// and a shared global (updateAvailableVersion) could otherwise mask a bug.
+func TestExtractCounterSystemUpdateUnavailable(t *testing.T) {
+	var m NcPerfData
+	m.Ocs.Data.Nextcloud.System.Update.Available = false
+
+	if got := extractCounter(m, "SystemUpdateAvailable"); got != 0 {
+		t.Errorf("extractCounter(m, \"SystemUpdateAvailable\") = %d, want 0", got)
+	}
+}
+
+// TestPrometheusMetricsGroupedByName guards against a future edit to
+// prometheusMetrics interleaving entries that share a metric name (e.g. the
+// CpuLoad* or ActiveUsers* family): writePrometheusTextfile only starts a new
+// HELP/TYPE block when pm.metric changes from the previous entry, so a
+// reordering that splits a metric name across two places would silently
+// emit a duplicate HELP/TYPE block and break the exposition format.
+func TestPrometheusMetricsGroupedByName(t *testing.T) {
+	seen := map[string]bool{}
+	lastMetric := ""
+	for _, pm := range prometheusMetrics {
+		if pm.metric != lastMetric {
+			if seen[pm.metric] {
+				t.Errorf("metric %q appears in more than one place in prometheusMetrics; entries sharing a metric name must stay adjacent", pm.metric)
+			}
+			seen[pm.metric] = true
+			lastMetric = pm.metric
+		}
+	}
+}